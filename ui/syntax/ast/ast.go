@@ -0,0 +1,316 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+// Package ast parses a wg-quick config buffer into a typed tree and
+// formats it back to text, so tooling can work against a stable
+// programmatic surface instead of hand-parsing SyntaxEdit.Text().
+package ast
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// interfaceKeyOrder and peerKeyOrder are the canonical key orderings Format
+// writes sections out in, matching the order wg-quick itself documents.
+var interfaceKeyOrder = []string{
+	"PrivateKey", "ListenPort", "FwMark", "Address", "DNS", "MTU", "Table",
+	"PreUp", "PostUp", "PreDown", "PostDown", "SaveConfig",
+}
+
+var peerKeyOrder = []string{
+	"PublicKey", "PresharedKey", "AllowedIPs", "Endpoint", "PersistentKeepalive",
+}
+
+// KeyValue is a single `Key = Value` line, along with whatever comments were
+// attached to it so a round-trip through Format doesn't lose them.
+type KeyValue struct {
+	Key           string
+	Value         string
+	Comments      []string // full-line comments immediately preceding this line
+	InlineComment string   // trailing "# ..." comment on the same line, without the "#"
+}
+
+// Section is an `[Interface]` or `[Peer]` block.
+type Section struct {
+	Name             string // "Interface" or "Peer"
+	Lines            []*KeyValue
+	Comments         []string // full-line comments immediately preceding the section header
+	TrailingComments []string // full-line comments after the last line, with nothing following them
+}
+
+// Get returns the value of the first line with the given key, and whether
+// one was found.
+func (s *Section) Get(key string) (string, bool) {
+	for _, kv := range s.Lines {
+		if strings.EqualFold(kv.Key, key) {
+			return kv.Value, true
+		}
+	}
+	return "", false
+}
+
+// Set overwrites the value of the first line with the given key, or
+// appends a new line if none exists.
+func (s *Section) Set(key, value string) {
+	for _, kv := range s.Lines {
+		if strings.EqualFold(kv.Key, key) {
+			kv.Value = value
+			return
+		}
+	}
+	s.Lines = append(s.Lines, &KeyValue{Key: key, Value: value})
+}
+
+// Config is a parsed wg-quick buffer: at most one [Interface] section
+// followed by any number of [Peer] sections.
+type Config struct {
+	Interface *Section
+	Peers     []*Section
+	// Comments holds comments that precede every section without being
+	// attached to one: a block separated from what follows by a blank
+	// line, or a buffer that is comments only.
+	Comments []string
+}
+
+// Parse reads a wg-quick style buffer into a Config, preserving comments by
+// attaching them to the section or key/value line that follows them.
+func Parse(text string) (*Config, error) {
+	cfg := &Config{}
+	var current *Section
+	var pendingComments []string
+
+	// A single trailing newline just terminates the last line; it isn't a
+	// blank-line separator that should drop a pending comment block.
+	text = strings.TrimSuffix(strings.TrimSuffix(text, "\n"), "\r")
+
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(strings.TrimRight(line, "\r"))
+		switch {
+		case trimmed == "":
+			// Inside a section, a blank line doesn't mean a pending comment
+			// was orphaned - it's still waiting to attach to whatever line
+			// or section follows. Only a blank line before any section has
+			// been seen separates unrelated top-level comments: they are
+			// kept on the Config rather than on the next section.
+			if current == nil {
+				cfg.Comments = append(cfg.Comments, pendingComments...)
+				pendingComments = nil
+			}
+		case strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";"):
+			pendingComments = append(pendingComments, strings.TrimSpace(trimmed[1:]))
+		case strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]"):
+			name := strings.TrimSpace(trimmed[1 : len(trimmed)-1])
+			current = &Section{Name: name, Comments: pendingComments}
+			pendingComments = nil
+			switch strings.ToLower(name) {
+			case "interface":
+				cfg.Interface = current
+			case "peer":
+				cfg.Peers = append(cfg.Peers, current)
+			}
+		default:
+			if current == nil {
+				return nil, fmt.Errorf("ast: key/value line outside of any section: %q", trimmed)
+			}
+			key, value, inline := splitKeyValue(trimmed)
+			if key == "" {
+				return nil, fmt.Errorf("ast: malformed line: %q", trimmed)
+			}
+			current.Lines = append(current.Lines, &KeyValue{
+				Key:           key,
+				Value:         value,
+				Comments:      pendingComments,
+				InlineComment: inline,
+			})
+			pendingComments = nil
+		}
+	}
+	if len(pendingComments) > 0 {
+		if current != nil {
+			current.TrailingComments = append(current.TrailingComments, pendingComments...)
+		} else {
+			cfg.Comments = append(cfg.Comments, pendingComments...)
+		}
+	}
+	return cfg, nil
+}
+
+// splitKeyValue splits "Key = Value # comment" into its parts. The value is
+// left unquoted/unescaped, matching wg-quick's own simple parser.
+func splitKeyValue(line string) (key, value, inlineComment string) {
+	eq := strings.IndexByte(line, '=')
+	if eq < 0 {
+		return "", "", ""
+	}
+	key = strings.TrimSpace(line[:eq])
+	rest := line[eq+1:]
+	if hash := strings.IndexByte(rest, '#'); hash >= 0 {
+		inlineComment = strings.TrimSpace(rest[hash+1:])
+		rest = rest[:hash]
+	}
+	value = strings.TrimSpace(rest)
+	return key, value, inlineComment
+}
+
+// FormatOptions controls how Config.Format renders a Config back to text.
+type FormatOptions struct {
+	// AlignEquals pads keys so every "=" in a section lines up in the same
+	// column.
+	AlignEquals bool
+	// NormalizeAllowedIPs dedupes, sorts, and collapses AllowedIPs entries
+	// that are already covered by a broader CIDR in the same line.
+	NormalizeAllowedIPs bool
+}
+
+// Format renders the Config back to wg-quick text, writing keys in
+// canonical order and preserving comments attached during Parse.
+func (c *Config) Format(opts FormatOptions) string {
+	var b strings.Builder
+	for _, comment := range c.Comments {
+		fmt.Fprintf(&b, "# %s\n", comment)
+	}
+	if c.Interface != nil {
+		writeSection(&b, c.Interface, interfaceKeyOrder, opts)
+	}
+	for _, peer := range c.Peers {
+		if b.Len() > 0 {
+			b.WriteByte('\n')
+		}
+		writeSection(&b, peer, peerKeyOrder, opts)
+	}
+	return b.String()
+}
+
+func writeSection(b *strings.Builder, s *Section, keyOrder []string, opts FormatOptions) {
+	for _, comment := range s.Comments {
+		fmt.Fprintf(b, "# %s\n", comment)
+	}
+	fmt.Fprintf(b, "[%s]\n", s.Name)
+
+	lines := orderLines(s.Lines, keyOrder)
+	width := 0
+	if opts.AlignEquals {
+		for _, kv := range lines {
+			if len(kv.Key) > width {
+				width = len(kv.Key)
+			}
+		}
+	}
+	for _, kv := range lines {
+		for _, comment := range kv.Comments {
+			fmt.Fprintf(b, "# %s\n", comment)
+		}
+		value := kv.Value
+		if opts.NormalizeAllowedIPs && strings.EqualFold(kv.Key, "AllowedIPs") {
+			value = normalizeAllowedIPs(value)
+		}
+		key := kv.Key
+		if opts.AlignEquals {
+			key = key + strings.Repeat(" ", width-len(key))
+		}
+		fmt.Fprintf(b, "%s = %s", key, value)
+		if kv.InlineComment != "" {
+			fmt.Fprintf(b, " # %s", kv.InlineComment)
+		}
+		b.WriteByte('\n')
+	}
+	for _, comment := range s.TrailingComments {
+		fmt.Fprintf(b, "# %s\n", comment)
+	}
+}
+
+// orderLines returns s's lines sorted by their position in keyOrder, with
+// unrecognized keys kept in their original relative order at the end.
+func orderLines(lines []*KeyValue, keyOrder []string) []*KeyValue {
+	rank := make(map[string]int, len(keyOrder))
+	for i, key := range keyOrder {
+		rank[strings.ToLower(key)] = i
+	}
+	ordered := make([]*KeyValue, len(lines))
+	copy(ordered, lines)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		ri, oki := rank[strings.ToLower(ordered[i].Key)]
+		rj, okj := rank[strings.ToLower(ordered[j].Key)]
+		if oki && okj {
+			return ri < rj
+		}
+		return oki && !okj
+	})
+	return ordered
+}
+
+// normalizeAllowedIPs dedupes, sorts, and collapses a comma-separated
+// AllowedIPs value, dropping any CIDR already covered by a broader one in
+// the same list. Entries that fail to parse as a CIDR are deduped but
+// otherwise left untouched, and passed through in their original relative
+// order, after the normalized ones.
+func normalizeAllowedIPs(value string) string {
+	var nets []*net.IPNet
+	netStrings := make(map[*net.IPNet]string)
+	var unparsed []string
+	seenNets := make(map[string]bool)
+	seenUnparsed := make(map[string]bool)
+
+	for _, raw := range strings.Split(value, ",") {
+		entry := strings.TrimSpace(raw)
+		if entry == "" {
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			if !seenUnparsed[entry] {
+				seenUnparsed[entry] = true
+				unparsed = append(unparsed, entry)
+			}
+			continue
+		}
+		// Dedupe on the normalized network, not the raw entry string, so
+		// e.g. "10.0.0.1/24" and "10.0.0.0/24" - the same network written
+		// two different ways - collapse to one.
+		key := ipNet.String()
+		if seenNets[key] {
+			continue
+		}
+		seenNets[key] = true
+		nets = append(nets, ipNet)
+		netStrings[ipNet] = key
+	}
+
+	var collapsed []*net.IPNet
+	for _, candidate := range nets {
+		covered := false
+		for _, other := range nets {
+			if other == candidate {
+				continue
+			}
+			if other.Contains(candidate.IP) && maskLen(other) < maskLen(candidate) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			collapsed = append(collapsed, candidate)
+		}
+	}
+
+	sort.Slice(collapsed, func(i, j int) bool {
+		return netStrings[collapsed[i]] < netStrings[collapsed[j]]
+	})
+
+	entries := make([]string, 0, len(collapsed)+len(unparsed))
+	for _, n := range collapsed {
+		entries = append(entries, netStrings[n])
+	}
+	entries = append(entries, unparsed...)
+	return strings.Join(entries, ", ")
+}
+
+func maskLen(n *net.IPNet) int {
+	ones, _ := n.Mask.Size()
+	return ones
+}
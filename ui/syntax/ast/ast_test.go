@@ -0,0 +1,156 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package ast
+
+import "testing"
+
+func TestParseFormatRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		opts FormatOptions
+		want string
+	}{
+		{
+			name: "basic interface and peer",
+			in: "[Interface]\n" +
+				"PrivateKey = abc\n" +
+				"Address = 10.0.0.1/24\n" +
+				"[Peer]\n" +
+				"PublicKey = def\n" +
+				"AllowedIPs = 0.0.0.0/0\n",
+			want: "[Interface]\n" +
+				"PrivateKey = abc\n" +
+				"Address = 10.0.0.1/24\n" +
+				"\n" +
+				"[Peer]\n" +
+				"PublicKey = def\n" +
+				"AllowedIPs = 0.0.0.0/0\n",
+		},
+		{
+			name: "canonicalizes key order",
+			in: "[Interface]\n" +
+				"Address = 10.0.0.1/24\n" +
+				"PrivateKey = abc\n",
+			want: "[Interface]\n" +
+				"PrivateKey = abc\n" +
+				"Address = 10.0.0.1/24\n",
+		},
+		{
+			name: "unrecognized keys kept at the end",
+			in: "[Interface]\n" +
+				"XCustom = foo\n" +
+				"PrivateKey = abc\n",
+			want: "[Interface]\n" +
+				"PrivateKey = abc\n" +
+				"XCustom = foo\n",
+		},
+		{
+			name: "preserves leading and inline comments",
+			in: "# a wg-quick config\n" +
+				"[Interface]\n" +
+				"# the private key\n" +
+				"PrivateKey = abc # keep secret\n",
+			want: "# a wg-quick config\n" +
+				"[Interface]\n" +
+				"# the private key\n" +
+				"PrivateKey = abc # keep secret\n",
+		},
+		{
+			name: "preserves a trailing comment at end of section",
+			in:   "[Interface]\nPrivateKey = abc\n# note\n",
+			want: "[Interface]\nPrivateKey = abc\n# note\n",
+		},
+		{
+			name: "preserves a trailing comment at end of buffer with no trailing newline",
+			in:   "[Peer]\nPublicKey = def\n# trailing, no newline after",
+			want: "[Peer]\nPublicKey = def\n# trailing, no newline after\n",
+		},
+		{
+			name: "preserves a comment with no section at all",
+			in:   "# just a comment, no sections\n",
+			want: "# just a comment, no sections\n",
+		},
+		{
+			name: "preserves a comment separated from its line by a blank line",
+			in:   "[Interface]\n# note\n\nPrivateKey = abc\n",
+			want: "[Interface]\n# note\nPrivateKey = abc\n",
+		},
+		{
+			name: "aligns equals signs",
+			in: "[Interface]\n" +
+				"PrivateKey = abc\n" +
+				"MTU = 1420\n",
+			opts: FormatOptions{AlignEquals: true},
+			want: "[Interface]\n" +
+				"PrivateKey = abc\n" +
+				"MTU        = 1420\n",
+		},
+		{
+			name: "normalizes AllowedIPs: dedupes, sorts, collapses covered CIDRs",
+			in:   "[Peer]\nPublicKey = def\nAllowedIPs = 10.0.0.1/24, 192.168.0.0/16, 10.0.0.0/24, 192.168.1.0/24\n",
+			opts: FormatOptions{NormalizeAllowedIPs: true},
+			want: "[Peer]\nPublicKey = def\nAllowedIPs = 10.0.0.0/24, 192.168.0.0/16\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := Parse(tt.in)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			got := cfg.Format(tt.opts)
+			if got != tt.want {
+				t.Errorf("Format() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{name: "key/value before any section", in: "PrivateKey = abc\n[Interface]\n"},
+		{name: "malformed line", in: "[Interface]\nnotakeyvalue\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.in); err == nil {
+				t.Fatalf("Parse(%q): expected an error, got none", tt.in)
+			}
+		})
+	}
+}
+
+func TestNormalizeAllowedIPsDedupesEquivalentLiterals(t *testing.T) {
+	got := normalizeAllowedIPs("10.0.0.1/24, 10.0.0.0/24")
+	want := "10.0.0.0/24"
+	if got != want {
+		t.Errorf("normalizeAllowedIPs() = %q, want %q", got, want)
+	}
+}
+
+func TestSectionGetSet(t *testing.T) {
+	s := &Section{Name: "Interface"}
+	if _, ok := s.Get("PrivateKey"); ok {
+		t.Fatal("Get on empty section should report not found")
+	}
+	s.Set("PrivateKey", "abc")
+	if v, ok := s.Get("PrivateKey"); !ok || v != "abc" {
+		t.Fatalf("Get(PrivateKey) = %q, %v, want \"abc\", true", v, ok)
+	}
+	s.Set("PrivateKey", "def")
+	if len(s.Lines) != 1 {
+		t.Fatalf("Set on an existing key should overwrite, not append; got %d lines", len(s.Lines))
+	}
+	if v, _ := s.Get("PrivateKey"); v != "def" {
+		t.Fatalf("Get(PrivateKey) after overwrite = %q, want \"def\"", v)
+	}
+}
@@ -7,15 +7,20 @@ package syntax
 
 import (
 	"errors"
+	"regexp"
 	"strings"
 	"syscall"
+	"time"
+	"unicode/utf16"
 	"unsafe"
 
 	"github.com/lxn/walk"
 	"github.com/lxn/win"
+
+	"golang.zx2c4.com/wireguard/windows/ui/syntax/ast"
 )
 
-// #cgo LDFLAGS: -lgdi32
+// #cgo LDFLAGS: -lgdi32 -luser32
 // #include "syntaxedit.h"
 import "C"
 
@@ -24,14 +29,197 @@ type SyntaxEdit struct {
 	textChangedPublisher            walk.EventPublisher
 	privateKeyPublisher             walk.StringEventPublisher
 	blockUntunneledTrafficPublisher walk.IntEventPublisher
+	syntaxModePublisher             SyntaxModeEventPublisher
+	diagnosticsPublisher            DiagnosticsEventPublisher
+	mode                            SyntaxMode
+	diagnostics                     []Diagnostic
+	linters                         []func(text string) []Diagnostic
+	historyPublisher                walk.EventPublisher
+	undoGroupDepth                  int
+	undoIdleWindow                  time.Duration
+	undoCoalesceTimer               *time.Timer
+	matchesPublisher                MatchesEventPublisher
+	matches                         []Range
+	currentMatch                    int
 }
 
+// defaultUndoIdleWindow is how long the control waits after the last
+// keystroke before closing the current undo group, so a burst of typing
+// collapses into one Undo/Redo step instead of one per character.
+const defaultUndoIdleWindow = 500 * time.Millisecond
+
 const (
 	InevaluableBlockingUntunneledTraffic = C.InevaluableBlockingUntunneledTraffic
 	BlockingUntunneledTraffic            = C.BlockingUntunneledTraffic
 	NotBlockingUntunneledTraffic         = C.NotBlockingUntunneledTraffic
 )
 
+// SyntaxMode selects which tokenizer table and validation rules the control
+// applies to its buffer. The zero value is SyntaxModeWgQuick, so embedders
+// that never call SetSyntaxMode keep today's behavior.
+type SyntaxMode int
+
+const (
+	SyntaxModeWgQuick SyntaxMode = iota
+	SyntaxModeOpenVPN
+	SyntaxModeSSHConfig
+	SyntaxModeHosts
+)
+
+// SyntaxModeEvent and its publisher mirror walk's other typed event helpers
+// (StringEvent, IntEvent) for a type walk itself doesn't define.
+type SyntaxModeEventHandler func(mode SyntaxMode)
+
+type SyntaxModeEvent struct {
+	handlers []SyntaxModeEventHandler
+}
+
+func (e *SyntaxModeEvent) Attach(handler SyntaxModeEventHandler) int {
+	handle := len(e.handlers)
+	e.handlers = append(e.handlers, handler)
+	return handle
+}
+
+func (e *SyntaxModeEvent) Detach(handle int) {
+	e.handlers[handle] = nil
+}
+
+type SyntaxModeEventPublisher struct {
+	event SyntaxModeEvent
+}
+
+func (p *SyntaxModeEventPublisher) Event() *SyntaxModeEvent {
+	return &p.event
+}
+
+func (p *SyntaxModeEventPublisher) Publish(mode SyntaxMode) {
+	for _, handler := range p.event.handlers {
+		if handler != nil {
+			handler(mode)
+		}
+	}
+}
+
+// Severity classifies a Diagnostic for display, e.g. as an icon in a
+// problems panel or the color of a squiggle underline.
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+	SeverityInfo
+)
+
+// Diagnostic describes one problem found in the buffer, either by the C
+// control's own grammar checks or by a linter registered with
+// RegisterLinter. Line and Col are zero-based; Length is in UTF-16 code
+// units, as reported by the native control.
+type Diagnostic struct {
+	Line     int
+	Col      int
+	Length   int
+	Severity Severity
+	Code     string
+	Message  string
+}
+
+// DiagnosticsEvent and its publisher follow the same pattern as
+// SyntaxModeEvent: a typed event for data walk doesn't know how to carry.
+type DiagnosticsEventHandler func(diagnostics []Diagnostic)
+
+type DiagnosticsEvent struct {
+	handlers []DiagnosticsEventHandler
+}
+
+func (e *DiagnosticsEvent) Attach(handler DiagnosticsEventHandler) int {
+	handle := len(e.handlers)
+	e.handlers = append(e.handlers, handler)
+	return handle
+}
+
+func (e *DiagnosticsEvent) Detach(handle int) {
+	e.handlers[handle] = nil
+}
+
+type DiagnosticsEventPublisher struct {
+	event DiagnosticsEvent
+}
+
+func (p *DiagnosticsEventPublisher) Event() *DiagnosticsEvent {
+	return &p.event
+}
+
+func (p *DiagnosticsEventPublisher) Publish(diagnostics []Diagnostic) {
+	for _, handler := range p.event.handlers {
+		if handler != nil {
+			handler(diagnostics)
+		}
+	}
+}
+
+// Range is a [Start, End) span of byte offsets into the string returned by
+// Text.
+type Range struct {
+	Start int
+	End   int
+}
+
+// FindOptions controls how Find locates matches in the buffer.
+type FindOptions struct {
+	Pattern       string
+	CaseSensitive bool
+	WholeWord     bool
+	Regexp        bool
+}
+
+func (opts FindOptions) compile() (*regexp.Regexp, error) {
+	pattern := opts.Pattern
+	if !opts.Regexp {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if opts.WholeWord {
+		pattern = `\b` + pattern + `\b`
+	}
+	if !opts.CaseSensitive {
+		pattern = "(?i)" + pattern
+	}
+	return regexp.Compile(pattern)
+}
+
+// MatchesEvent and its publisher follow the same pattern as
+// SyntaxModeEvent: a typed event for data walk doesn't know how to carry.
+type MatchesEventHandler func(matches []Range)
+
+type MatchesEvent struct {
+	handlers []MatchesEventHandler
+}
+
+func (e *MatchesEvent) Attach(handler MatchesEventHandler) int {
+	handle := len(e.handlers)
+	e.handlers = append(e.handlers, handler)
+	return handle
+}
+
+func (e *MatchesEvent) Detach(handle int) {
+	e.handlers[handle] = nil
+}
+
+type MatchesEventPublisher struct {
+	event MatchesEvent
+}
+
+func (p *MatchesEventPublisher) Event() *MatchesEvent {
+	return &p.event
+}
+
+func (p *MatchesEventPublisher) Publish(matches []Range) {
+	for _, handler := range p.event.handlers {
+		if handler != nil {
+			handler(matches)
+		}
+	}
+}
+
 func (se *SyntaxEdit) LayoutFlags() walk.LayoutFlags {
 	return walk.GrowableHorz | walk.GrowableVert | walk.GreedyHorz | walk.GreedyVert
 }
@@ -59,9 +247,16 @@ func (se *SyntaxEdit) SetText(text string) (err error) {
 	if text == se.Text() {
 		return nil
 	}
+	se.BeginUndoGroup()
+	defer se.EndUndoGroup()
 	text = strings.Replace(text, "\n", "\r\n", -1)
-	if win.TRUE != se.SendMessage(win.WM_SETTEXT, 0, uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr(text)))) {
-		err = errors.New("WM_SETTEXT failed")
+	// Plain WM_SETTEXT resets RichEdit's undo buffer instead of recording
+	// into it, so programmatic replacement would never be undoable. Use
+	// EM_SETTEXTEX with ST_KEEPUNDO, which is documented to behave like
+	// WM_SETTEXT but push a single undoable step.
+	textex := C.SETTEXTEX{flags: C.ST_KEEPUNDO, codepage: C.CP_WINUNICODE}
+	if se.SendMessage(C.EM_SETTEXTEX, uintptr(unsafe.Pointer(&textex)), uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr(text)))) == 0 {
+		err = errors.New("EM_SETTEXTEX failed")
 	}
 	se.textChangedPublisher.Publish()
 	return
@@ -79,12 +274,321 @@ func (se *SyntaxEdit) BlockUntunneledTrafficStateChanged() *walk.IntEvent {
 	return se.blockUntunneledTrafficPublisher.Event()
 }
 
+// SetSyntaxMode switches the tokenizer table and validation rules the C
+// control highlights and lints the buffer with. Existing text is
+// retokenized and revalidated in place; it is not reformatted.
+func (se *SyntaxEdit) SetSyntaxMode(mode SyntaxMode) {
+	if mode == se.mode {
+		return
+	}
+	se.mode = mode
+	se.SendMessage(C.SE_SET_MODE, uintptr(mode), 0)
+	se.syntaxModePublisher.Publish(mode)
+}
+
+func (se *SyntaxEdit) SyntaxMode() SyntaxMode {
+	return se.mode
+}
+
+func (se *SyntaxEdit) SyntaxModeChanged() *SyntaxModeEvent {
+	return se.syntaxModePublisher.Event()
+}
+
+// Diagnostics returns the most recently published set of problems found in
+// the buffer, combining the C control's grammar checks with the output of
+// any linters registered via RegisterLinter.
+func (se *SyntaxEdit) Diagnostics() []Diagnostic {
+	return se.diagnostics
+}
+
+func (se *SyntaxEdit) DiagnosticsChanged() *DiagnosticsEvent {
+	return se.diagnosticsPublisher.Event()
+}
+
+// RegisterLinter adds an application-supplied validator that is run against
+// the buffer's text whenever it changes, in addition to the control's own
+// built-in checks. Diagnostics from every registered linter are merged
+// into the set reported by Diagnostics and DiagnosticsChanged.
+func (se *SyntaxEdit) RegisterLinter(linter func(text string) []Diagnostic) {
+	se.linters = append(se.linters, linter)
+}
+
+// relint re-runs every registered linter and merges the results with the
+// diagnostics most recently reported by the C control, then republishes.
+func (se *SyntaxEdit) relint(native []Diagnostic) {
+	diagnostics := native
+	if len(se.linters) > 0 {
+		text := se.Text()
+		for _, linter := range se.linters {
+			diagnostics = append(diagnostics, linter(text)...)
+		}
+	}
+	se.diagnostics = diagnostics
+	se.diagnosticsPublisher.Publish(diagnostics)
+}
+
+// Undo reverts the most recent undo group. It reports whether RichEdit had
+// anything to undo.
+func (se *SyntaxEdit) Undo() bool {
+	ok := se.SendMessage(win.EM_UNDO, 0, 0) != 0
+	if ok {
+		se.historyPublisher.Publish()
+	}
+	return ok
+}
+
+// Redo re-applies the most recently undone group. It reports whether
+// RichEdit had anything to redo.
+func (se *SyntaxEdit) Redo() bool {
+	ok := se.SendMessage(win.EM_REDO, 0, 0) != 0
+	if ok {
+		se.historyPublisher.Publish()
+	}
+	return ok
+}
+
+func (se *SyntaxEdit) CanUndo() bool {
+	return se.SendMessage(win.EM_CANUNDO, 0, 0) != 0
+}
+
+func (se *SyntaxEdit) CanRedo() bool {
+	return se.SendMessage(win.EM_CANREDO, 0, 0) != 0
+}
+
+// BeginUndoGroup and EndUndoGroup bracket a sequence of edits, typically
+// from a config-generator tool calling SetText, so they collapse into a
+// single Undo/Redo step instead of one per underlying RichEdit operation.
+// Calls nest: only the outermost pair closes the group.
+func (se *SyntaxEdit) BeginUndoGroup() {
+	if se.undoGroupDepth == 0 {
+		se.stopUndoCoalescing()
+		se.SendMessage(win.EM_STOPGROUPTYPING, 0, 0)
+	}
+	se.undoGroupDepth++
+}
+
+func (se *SyntaxEdit) EndUndoGroup() {
+	if se.undoGroupDepth == 0 {
+		return
+	}
+	se.undoGroupDepth--
+	if se.undoGroupDepth == 0 {
+		se.SendMessage(win.EM_STOPGROUPTYPING, 0, 0)
+		se.historyPublisher.Publish()
+	}
+}
+
+// SetUndoIdleWindow overrides how long the control waits after the last
+// keystroke before closing the current undo group. The default is
+// defaultUndoIdleWindow.
+func (se *SyntaxEdit) SetUndoIdleWindow(d time.Duration) {
+	se.undoIdleWindow = d
+}
+
+func (se *SyntaxEdit) HistoryChanged() *walk.Event {
+	return se.historyPublisher.Event()
+}
+
+// scheduleUndoGroupBoundary restarts the idle timer that closes the
+// current typing-coalesced undo group. It does nothing while inside an
+// explicit BeginUndoGroup/EndUndoGroup bracket, which owns its own
+// boundaries.
+func (se *SyntaxEdit) scheduleUndoGroupBoundary() {
+	if se.undoGroupDepth > 0 {
+		return
+	}
+	se.stopUndoCoalescing()
+	se.undoCoalesceTimer = time.AfterFunc(se.undoIdleWindow, func() {
+		se.Synchronize(func() {
+			se.SendMessage(win.EM_STOPGROUPTYPING, 0, 0)
+			se.historyPublisher.Publish()
+		})
+	})
+}
+
+func (se *SyntaxEdit) stopUndoCoalescing() {
+	if se.undoCoalesceTimer != nil {
+		se.undoCoalesceTimer.Stop()
+		se.undoCoalesceTimer = nil
+	}
+}
+
+// Find locates every match of opts.Pattern in the buffer, paints them via
+// SE_SET_MATCHES, and returns them. The first match becomes the current
+// match for FindNext/FindPrev.
+func (se *SyntaxEdit) Find(opts FindOptions) ([]Range, error) {
+	re, err := opts.compile()
+	if err != nil {
+		return nil, err
+	}
+	text := se.Text()
+	matches := make([]Range, 0)
+	for _, loc := range re.FindAllStringIndex(text, -1) {
+		matches = append(matches, Range{Start: loc[0], End: loc[1]})
+	}
+	se.setMatches(matches)
+	if len(matches) > 0 {
+		se.scrollToMatch(0)
+	}
+	return matches, nil
+}
+
+func (se *SyntaxEdit) setMatches(matches []Range) {
+	se.matches = matches
+	se.currentMatch = -1
+	text := se.Text()
+	packed := make([]C.se_range, len(matches))
+	for i, r := range matches {
+		// se_range is in UTF-16 code units, same as the native buffer;
+		// Range is in byte offsets into se.Text(), same as scrollToMatch.
+		packed[i] = C.se_range{
+			start: C.int(utf16Len(text[:r.Start])),
+			end:   C.int(utf16Len(text[:r.End])),
+		}
+	}
+	list := C.se_range_list{count: C.int(len(packed))}
+	if len(packed) > 0 {
+		list.items = &packed[0]
+	}
+	se.SendMessage(C.SE_SET_MATCHES, 0, uintptr(unsafe.Pointer(&list)))
+	se.matchesPublisher.Publish(matches)
+}
+
+// FindNext and FindPrev move the current match among the set found by the
+// last Find call, wrapping around, and scroll the new current match into
+// view. They report false if there are no matches.
+func (se *SyntaxEdit) FindNext() bool {
+	if len(se.matches) == 0 {
+		return false
+	}
+	se.scrollToMatch((se.currentMatch + 1) % len(se.matches))
+	return true
+}
+
+func (se *SyntaxEdit) FindPrev() bool {
+	if len(se.matches) == 0 {
+		return false
+	}
+	se.scrollToMatch((se.currentMatch - 1 + len(se.matches)) % len(se.matches))
+	return true
+}
+
+func (se *SyntaxEdit) scrollToMatch(index int) {
+	se.currentMatch = index
+	r := se.matches[index]
+	text := se.Text()
+	start := utf16Len(text[:r.Start])
+	end := utf16Len(text[:r.End])
+	se.SendMessage(win.EM_SETSEL, uintptr(start), uintptr(end))
+	se.SendMessage(win.EM_SCROLLCARET, 0, 0)
+}
+
+// Replace substitutes the current match (as left by Find/FindNext/FindPrev)
+// with replacement and re-runs Find with the same options so the match set
+// stays current. It reports false if there is no current match.
+func (se *SyntaxEdit) Replace(opts FindOptions, replacement string) (bool, error) {
+	if se.currentMatch < 0 || se.currentMatch >= len(se.matches) {
+		return false, nil
+	}
+	r := se.matches[se.currentMatch]
+	text := se.Text()
+	if err := se.SetText(text[:r.Start] + replacement + text[r.End:]); err != nil {
+		return false, err
+	}
+	_, err := se.Find(opts)
+	return true, err
+}
+
+// ReplaceAll substitutes every match of opts.Pattern with replacement in a
+// single undo group and returns how many replacements were made.
+func (se *SyntaxEdit) ReplaceAll(opts FindOptions, replacement string) (int, error) {
+	re, err := opts.compile()
+	if err != nil {
+		return 0, err
+	}
+	text := se.Text()
+	count := 0
+	replaced := re.ReplaceAllStringFunc(text, func(match string) string {
+		count++
+		return replacement
+	})
+	if count == 0 {
+		return 0, nil
+	}
+	if err := se.SetText(replaced); err != nil {
+		return 0, err
+	}
+	se.setMatches(nil)
+	return count, nil
+}
+
+func (se *SyntaxEdit) MatchesChanged() *MatchesEvent {
+	return se.matchesPublisher.Event()
+}
+
+// AST parses the current buffer into a typed Config, giving tooling
+// authors a stable programmatic surface instead of hand-parsing Text().
+func (se *SyntaxEdit) AST() (*ast.Config, error) {
+	return ast.Parse(se.Text())
+}
+
+// SetAST formats cfg and replaces the buffer with the result, as a single
+// undo step.
+func (se *SyntaxEdit) SetAST(cfg *ast.Config) error {
+	return se.SetText(cfg.Format(ast.FormatOptions{}))
+}
+
+// Format parses the current buffer and rewrites it canonicalized according
+// to opts, as a single undo step. It's the implementation behind a "Format
+// Document" command.
+func (se *SyntaxEdit) Format(opts ast.FormatOptions) error {
+	cfg, err := se.AST()
+	if err != nil {
+		return err
+	}
+	return se.SetText(cfg.Format(opts))
+}
+
+// utf16Len reports the length of s in UTF-16 code units, which is the unit
+// RichEdit's EM_SETSEL expects, as opposed to Go's byte- or rune-indexing.
+func utf16Len(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
+
+// diagnosticsFromLParam decodes the SE_DIAGNOSTICS payload: a
+// *C.se_diagnostic_list whose items field points at count contiguous
+// C.se_diagnostic entries.
+func diagnosticsFromLParam(lParam uintptr) []Diagnostic {
+	if lParam == 0 {
+		return nil
+	}
+	list := (*C.se_diagnostic_list)(unsafe.Pointer(lParam))
+	count := int(list.count)
+	if count == 0 {
+		return nil
+	}
+	items := (*[1 << 20]C.se_diagnostic)(unsafe.Pointer(list.items))[:count:count]
+	diagnostics := make([]Diagnostic, count)
+	for i, item := range items {
+		diagnostics[i] = Diagnostic{
+			Line:     int(item.line),
+			Col:      int(item.col),
+			Length:   int(item.length),
+			Severity: Severity(item.severity),
+			Code:     C.GoString(item.code),
+			Message:  C.GoString(item.message),
+		}
+	}
+	return diagnostics
+}
+
 func (se *SyntaxEdit) WndProc(hwnd win.HWND, msg uint32, wParam, lParam uintptr) uintptr {
 	switch msg {
 	case win.WM_NOTIFY, win.WM_COMMAND:
 		switch win.HIWORD(uint32(wParam)) {
 		case win.EN_CHANGE:
 			se.textChangedPublisher.Publish()
+			se.scheduleUndoGroupBoundary()
 		}
 		// This is a horrible trick from MFC where we reflect the event back to the child.
 		se.SendMessage(msg+C.WM_REFLECT, wParam, lParam)
@@ -96,13 +600,15 @@ func (se *SyntaxEdit) WndProc(hwnd win.HWND, msg uint32, wParam, lParam uintptr)
 		}
 	case C.SE_TRAFFIC_BLOCK:
 		se.blockUntunneledTrafficPublisher.Publish(int(lParam))
+	case C.SE_DIAGNOSTICS:
+		se.relint(diagnosticsFromLParam(lParam))
 	}
 	return se.WidgetBase.WndProc(hwnd, msg, wParam, lParam)
 }
 
 func NewSyntaxEdit(parent walk.Container) (*SyntaxEdit, error) {
 	C.register_syntax_edit()
-	se := &SyntaxEdit{}
+	se := &SyntaxEdit{undoIdleWindow: defaultUndoIdleWindow}
 	err := walk.InitWidget(
 		se,
 		parent,
@@ -114,6 +620,7 @@ func NewSyntaxEdit(parent walk.Container) (*SyntaxEdit, error) {
 		return nil, err
 	}
 	se.SendMessage(C.SE_SET_PARENT_DPI, uintptr(parent.DPI()), 0)
+	se.SendMessage(C.SE_SET_MODE, uintptr(se.mode), 0)
 
 	se.GraphicsEffects().Add(walk.InteractionEffect)
 	se.GraphicsEffects().Add(walk.FocusEffect)